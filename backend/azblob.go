@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+func init() {
+	Register("azblob", newAzBlobBackend)
+}
+
+// azBlobBackend stores objects as "<prefix>/<oid>" blobs in a single
+// container.
+type azBlobBackend struct {
+	container *container.Client
+	prefix    string
+	cfg       *Config
+}
+
+func newAzBlobBackend(u *url.URL, cfg *Config) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("azblob remote requires a container, e.g. azblob://container/prefix")
+	}
+	if cfg.Endpoint == "" || cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("azblob remote requires endpoint, access_key_id and secret_access_key in remotes.conf")
+	}
+
+	cred, err := azblob.NewSharedKeyCredential(cfg.AccessKeyID, cfg.SecretAccessKey)
+	if err != nil {
+		return nil, fmt.Errorf("building azure credential: %v", err)
+	}
+
+	containerURL := strings.TrimRight(cfg.Endpoint, "/") + "/" + u.Host
+	client, err := container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating azure container client: %v", err)
+	}
+
+	return &azBlobBackend{container: client, prefix: strings.Trim(u.Path, "/"), cfg: cfg}, nil
+}
+
+func (b *azBlobBackend) key(oid string) string {
+	if b.prefix == "" {
+		return oid
+	}
+	return b.prefix + "/" + oid
+}
+
+func (b *azBlobBackend) Stat(oid string) (int64, error) {
+	props, err := b.container.NewBlobClient(b.key(oid)).GetProperties(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	if props.ContentLength == nil {
+		return 0, fmt.Errorf("no content length for %v", oid)
+	}
+	return *props.ContentLength, nil
+}
+
+func (b *azBlobBackend) Exists(oid string) bool {
+	_, err := b.Stat(oid)
+	return err == nil
+}
+
+func (b *azBlobBackend) NewReader(oid string) (io.ReadCloser, error) {
+	resp, err := b.container.NewBlobClient(b.key(oid)).DownloadStream(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *azBlobBackend) Remove(oid string) error {
+	_, err := b.container.NewBlobClient(b.key(oid)).Delete(context.Background(), nil)
+	return err
+}
+
+func (b *azBlobBackend) Walk(fn func(oid string) error) error {
+	listPrefix := b.prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+
+	pager := b.container.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: &listPrefix,
+	})
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			oid := strings.TrimPrefix(*item.Name, listPrefix)
+			if !isOIDName(oid) {
+				continue
+			}
+			if err := fn(oid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *azBlobBackend) Quarantine(oid string) error {
+	ctx := context.Background()
+	src := b.container.NewBlobClient(b.key(oid))
+	dst := b.container.NewBlobClient(b.key(oid) + ".corrupt")
+
+	if _, err := dst.StartCopyFromURL(ctx, src.URL(), nil); err != nil {
+		return err
+	}
+	return b.Remove(oid)
+}
+
+// azBlobWriter streams writes into UploadStream via an io.Pipe, so the
+// upload only completes, and the blob only becomes visible, once Commit
+// is called; Abort cancels it instead.
+type azBlobWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (b *azBlobBackend) NewWriter(oid string, sizeHint int64) (PendingWriter, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	blockBlob := b.container.NewBlockBlobClient(b.key(oid))
+	opts := &azblob.UploadStreamOptions{}
+	if b.cfg.ChunkSize > 0 {
+		opts.BlockSize = b.cfg.ChunkSize
+	}
+	if b.cfg.AccessTier != "" {
+		tier := blob.AccessTier(b.cfg.AccessTier)
+		opts.AccessTier = &tier
+	}
+
+	go func() {
+		_, err := blockBlob.UploadStream(context.Background(), pr, *opts)
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return &azBlobWriter{pw: pw, done: done}, nil
+}
+
+func (w *azBlobWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *azBlobWriter) Commit() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
+
+func (w *azBlobWriter) Abort() error {
+	w.pw.CloseWithError(fmt.Errorf("upload aborted"))
+	<-w.done
+	return nil
+}
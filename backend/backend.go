@@ -0,0 +1,91 @@
+// Package backend abstracts over where LFS objects actually live, so the
+// transfer protocol in package service is not hard-wired to a local
+// filesystem tree. Each scheme (file, s3, azblob, sftp, gs) registers a
+// Factory that turns a parsed remote URL and its Config into a Backend.
+package backend
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Backend is the minimal set of operations Serve needs against whatever
+// is holding LFS objects, keyed by their OID.
+type Backend interface {
+	// Stat returns the size in bytes of the object named by oid.
+	Stat(oid string) (int64, error)
+	// Exists reports whether an object named by oid is already present.
+	Exists(oid string) bool
+	// NewReader opens the object named by oid for reading.
+	NewReader(oid string) (io.ReadCloser, error)
+	// NewWriter opens the object named by oid for staging a new write.
+	// sizeHint is the final size in bytes if known exactly, or 0
+	// otherwise (e.g. when the data will be compressed on the way in);
+	// backends that can benefit from knowing it up front, such as
+	// preallocating disk space, may use it, and must tolerate 0. A
+	// non-zero sizeHint must be exact: the file backend preallocates that
+	// many bytes, so writing fewer leaves the object padded with zeros.
+	//
+	// The returned PendingWriter must not be visible to
+	// Stat/Exists/NewReader until its Commit has returned successfully,
+	// so the caller can verify the data (e.g. check its hash) before
+	// deciding whether to publish it.
+	NewWriter(oid string, sizeHint int64) (PendingWriter, error)
+	// Remove deletes the object named by oid.
+	Remove(oid string) error
+	// Walk calls fn once for every object currently stored, so tools like
+	// fsck can enumerate a remote without backend-specific knowledge.
+	// Iteration stops at the first error fn returns (other than
+	// skipping); Walk propagates it to its own caller.
+	Walk(fn func(oid string) error) error
+	// Quarantine marks the object named by oid as corrupt so it won't be
+	// served again, without necessarily deleting it outright.
+	Quarantine(oid string) error
+}
+
+// PendingWriter stages a new object's bytes. Call Commit to publish it,
+// or Abort to discard it; either call releases the writer's underlying
+// resources, so exactly one of them must be called.
+type PendingWriter interface {
+	io.Writer
+	// Commit publishes the staged bytes as the final object named by the
+	// oid NewWriter was opened with.
+	Commit() error
+	// Abort discards the staged bytes. The object must not become
+	// visible.
+	Abort() error
+}
+
+// Factory constructs a Backend from a parsed remote URL and its resolved
+// Config. Implementations register one via Register from an init() func.
+type Factory func(u *url.URL, cfg *Config) (Backend, error)
+
+var factories = map[string]Factory{}
+
+// Register adds a Factory for the given URL scheme.
+func Register(scheme string, f Factory) {
+	factories[scheme] = f
+}
+
+// New resolves remote (e.g. "/var/lfs", "file:///var/lfs", "s3://bucket/prefix")
+// to a Backend, loading its Config via LoadConfig.
+func New(remote string) (Backend, error) {
+	u, err := url.Parse(remote)
+	if err != nil || u.Scheme == "" {
+		// Bare paths are treated as local folders, same as the historical baseDir.
+		u = &url.URL{Scheme: "file", Path: remote}
+	}
+
+	f, ok := factories[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("no backend registered for scheme %q", u.Scheme)
+	}
+
+	cfg, err := LoadConfig(u.Scheme)
+	if err != nil {
+		return nil, fmt.Errorf("loading config for %q: %v", remote, err)
+	}
+
+	return f(u, cfg)
+}
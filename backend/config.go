@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sinbad/lfs-folderstore/util"
+)
+
+// Config holds the connection details for a single remote backend,
+// modeled loosely on an rclone remote: credentials, an endpoint
+// override, a chunk size for multipart transfers, and a storage access
+// tier where the backend supports one.
+type Config struct {
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+	ChunkSize       int64
+	AccessTier      string
+	// Extra carries any backend-specific keys not covered above (e.g.
+	// SFTP's "key_file" or "known_hosts_file") straight from the conf
+	// file section or git config.
+	Extra map[string]string
+}
+
+const defaultChunkSize = 16 * 1024 * 1024
+
+// LoadConfig resolves the Config for scheme. It reads
+// ~/.config/lfs-folderstore/remotes.conf (an rclone-style ini file, one
+// section per scheme name) first, then lets any
+// lfs.customtransfer.folderstore.<scheme>.<key> git config value
+// override it, so a per-repo override wins over the shared remotes file.
+func LoadConfig(scheme string) (*Config, error) {
+	cfg := &Config{ChunkSize: defaultChunkSize, Extra: map[string]string{}}
+
+	if path, err := remotesConfPath(); err == nil {
+		if section, err := readIniSection(path, scheme); err == nil {
+			applyConfig(cfg, section)
+		}
+	}
+
+	applyConfig(cfg, gitConfigSection(scheme))
+
+	return cfg, nil
+}
+
+func remotesConfPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "lfs-folderstore", "remotes.conf"), nil
+}
+
+// readIniSection reads a single [scheme] section of an rclone-style ini
+// file into a key/value map.
+func readIniSection(path, section string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]") == section
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			values[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// gitConfigSection reads every
+// lfs.customtransfer.folderstore.<scheme>.<key> value set for scheme.
+func gitConfigSection(scheme string) map[string]string {
+	values := map[string]string{}
+
+	prefix := fmt.Sprintf("lfs.customtransfer.folderstore.%s.", scheme)
+	cmd := util.NewCmd("git", "config", "--get-regexp", "^"+strings.ReplaceAll(prefix, ".", `\.`))
+	out, err := cmd.Output()
+	if err != nil {
+		return values
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		values[strings.TrimPrefix(key, prefix)] = value
+	}
+	return values
+}
+
+func applyConfig(cfg *Config, values map[string]string) {
+	for k, v := range values {
+		switch k {
+		case "endpoint":
+			cfg.Endpoint = v
+		case "access_key_id":
+			cfg.AccessKeyID = v
+		case "secret_access_key":
+			cfg.SecretAccessKey = v
+		case "region":
+			cfg.Region = v
+		case "chunk_size":
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				cfg.ChunkSize = n
+			}
+		case "access_tier":
+			cfg.AccessTier = v
+		default:
+			cfg.Extra[k] = v
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package backend
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes of disk for f up front. It's
+// best-effort: failures are ignored since the write that follows works
+// fine without it.
+func preallocate(f *os.File, size int64) {
+	if size <= 0 {
+		return
+	}
+	unix.Fallocate(int(f.Fd()), 0, 0, size)
+}
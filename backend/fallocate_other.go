@@ -0,0 +1,9 @@
+//go:build !linux
+
+package backend
+
+import "os"
+
+// preallocate is a no-op outside Linux, which is where fallocate(2) is
+// available; see the linux build of this file.
+func preallocate(f *os.File, size int64) {}
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestFileBackend(t *testing.T) *fileBackend {
+	t.Helper()
+	dir := t.TempDir()
+	b, err := newFileBackend(&url.URL{Path: dir}, &Config{})
+	if err != nil {
+		t.Fatalf("newFileBackend: %v", err)
+	}
+	return b.(*fileBackend)
+}
+
+func TestFileBackendAbortNeverPublishes(t *testing.T) {
+	b := newTestFileBackend(t)
+	const oid = "deadbeef"
+
+	w, err := b.NewWriter(oid, 0)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if _, err := w.Write([]byte("corrupt content")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+
+	if b.Exists(oid) {
+		t.Fatalf("object became visible after Abort")
+	}
+	if _, err := os.Stat(b.path(oid) + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temp file %q.tmp was not cleaned up after Abort", b.path(oid))
+	}
+}
+
+func TestFileBackendCommitPublishes(t *testing.T) {
+	b := newTestFileBackend(t)
+	const oid = "cafef00d"
+	want := []byte("verified content")
+
+	w, err := b.NewWriter(oid, int64(len(want)))
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if _, err := w.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// Not visible until Commit, even though the bytes are already
+	// flushed to the temp file on disk.
+	if b.Exists(oid) {
+		t.Fatalf("object visible before Commit")
+	}
+
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if !b.Exists(oid) {
+		t.Fatalf("object not visible after Commit")
+	}
+	r, err := b.NewReader(oid)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestFileBackendPath(t *testing.T) {
+	b := &fileBackend{baseDir: "/base"}
+	oid := "0123456789abcdef"
+	want := filepath.Join("/base", "01", "23", oid)
+	if got := b.path(oid); got != want {
+		t.Fatalf("path(%q) = %q, want %q", oid, got, want)
+	}
+}
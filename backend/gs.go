@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+func init() {
+	Register("gs", newGSBackend)
+}
+
+// gsBackend stores objects as "<prefix>/<oid>" objects in a single
+// bucket.
+type gsBackend struct {
+	bucket *storage.BucketHandle
+	prefix string
+	cfg    *Config
+}
+
+func newGSBackend(u *url.URL, cfg *Config) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("gs remote requires a bucket, e.g. gs://bucket/prefix")
+	}
+
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("creating GCS client: %v", err)
+	}
+
+	return &gsBackend{bucket: client.Bucket(u.Host), prefix: strings.Trim(u.Path, "/"), cfg: cfg}, nil
+}
+
+func (b *gsBackend) key(oid string) string {
+	if b.prefix == "" {
+		return oid
+	}
+	return b.prefix + "/" + oid
+}
+
+func (b *gsBackend) object(oid string) *storage.ObjectHandle {
+	return b.bucket.Object(b.key(oid))
+}
+
+func (b *gsBackend) Stat(oid string) (int64, error) {
+	attrs, err := b.object(oid).Attrs(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+func (b *gsBackend) Exists(oid string) bool {
+	_, err := b.Stat(oid)
+	return err == nil
+}
+
+func (b *gsBackend) NewReader(oid string) (io.ReadCloser, error) {
+	return b.object(oid).NewReader(context.Background())
+}
+
+func (b *gsBackend) NewWriter(oid string, sizeHint int64) (PendingWriter, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := b.object(oid).NewWriter(ctx)
+	if b.cfg.ChunkSize > 0 {
+		w.ChunkSize = int(b.cfg.ChunkSize)
+	}
+	if b.cfg.AccessTier != "" {
+		w.StorageClass = b.cfg.AccessTier
+	}
+	return &gsWriter{w: w, cancel: cancel}, nil
+}
+
+func (b *gsBackend) Remove(oid string) error {
+	return b.object(oid).Delete(context.Background())
+}
+
+func (b *gsBackend) Walk(fn func(oid string) error) error {
+	listPrefix := b.prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+
+	it := b.bucket.Objects(context.Background(), &storage.Query{Prefix: listPrefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		oid := strings.TrimPrefix(attrs.Name, listPrefix)
+		if !isOIDName(oid) {
+			continue
+		}
+		if err := fn(oid); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *gsBackend) Quarantine(oid string) error {
+	ctx := context.Background()
+	dst := b.object(oid + ".corrupt")
+	if _, err := dst.CopierFrom(b.object(oid)).Run(ctx); err != nil {
+		return err
+	}
+	return b.Remove(oid)
+}
+
+// gsWriter wraps *storage.Writer, which already only finalizes (and
+// makes the object visible) when Close is called, with the
+// Commit/Abort split the rest of the service expects; Abort cancels the
+// writer's context instead of letting it finalize.
+type gsWriter struct {
+	w      *storage.Writer
+	cancel context.CancelFunc
+}
+
+func (w *gsWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *gsWriter) Commit() error {
+	defer w.cancel()
+	return w.w.Close()
+}
+
+func (w *gsWriter) Abort() error {
+	w.cancel()
+	w.w.Close()
+	return nil
+}
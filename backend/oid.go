@@ -0,0 +1,18 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// isOIDName reports whether name could be a sha256 hex digest, the
+// shape every object's filename/key takes; Walk implementations use
+// this to skip over temp/quarantine files sitting alongside real
+// objects.
+func isOIDName(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
@@ -0,0 +1,195 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+func init() {
+	Register("s3", newS3Backend)
+}
+
+// s3Backend stores objects as "<prefix>/<oid>" keys in a single bucket.
+type s3Backend struct {
+	client *s3.Client
+	bucket string
+	prefix string
+	cfg    *Config
+}
+
+func newS3Backend(u *url.URL, cfg *Config) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("s3 remote requires a bucket, e.g. s3://bucket/prefix")
+	}
+
+	var optFns []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		optFns = append(optFns, config.WithRegion(cfg.Region))
+	}
+	if cfg.AccessKeyID != "" {
+		optFns = append(optFns, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(context.Background(), optFns...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %v", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &s3Backend{client: client, bucket: u.Host, prefix: strings.Trim(u.Path, "/"), cfg: cfg}, nil
+}
+
+func (b *s3Backend) key(oid string) string {
+	if b.prefix == "" {
+		return oid
+	}
+	return b.prefix + "/" + oid
+}
+
+func (b *s3Backend) Stat(oid string) (int64, error) {
+	out, err := b.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(oid)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+func (b *s3Backend) Exists(oid string) bool {
+	_, err := b.Stat(oid)
+	return err == nil
+}
+
+func (b *s3Backend) NewReader(oid string) (io.ReadCloser, error) {
+	out, err := b.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(oid)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (b *s3Backend) Remove(oid string) error {
+	_, err := b.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(oid)),
+	})
+	return err
+}
+
+func (b *s3Backend) Walk(fn func(oid string) error) error {
+	listPrefix := b.prefix
+	if listPrefix != "" {
+		listPrefix += "/"
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(listPrefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			oid := strings.TrimPrefix(aws.ToString(obj.Key), listPrefix)
+			if !isOIDName(oid) {
+				continue
+			}
+			if err := fn(oid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (b *s3Backend) Quarantine(oid string) error {
+	ctx := context.Background()
+	src := b.bucket + "/" + b.key(oid)
+	dst := b.key(oid) + ".corrupt"
+	if _, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(b.bucket),
+		Key:        aws.String(dst),
+		CopySource: aws.String(src),
+	}); err != nil {
+		return err
+	}
+	return b.Remove(oid)
+}
+
+// s3Writer adapts manager.Uploader, which wants to pull from an
+// io.Reader, to the plain PendingWriter the rest of the service writes
+// to. The upload only completes, and the object only becomes visible in
+// the bucket, once Commit is called; Abort cancels it instead.
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (b *s3Backend) NewWriter(oid string, sizeHint int64) (PendingWriter, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	uploader := manager.NewUploader(b.client, func(u *manager.Uploader) {
+		if b.cfg.ChunkSize > 0 {
+			u.PartSize = b.cfg.ChunkSize
+		}
+	})
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(oid)),
+		Body:   pr,
+	}
+	if b.cfg.AccessTier != "" {
+		input.StorageClass = types.StorageClass(b.cfg.AccessTier)
+	}
+
+	go func() {
+		_, err := uploader.Upload(context.Background(), input)
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return &s3Writer{pw: pw, done: done}, nil
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Commit() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
+
+func (w *s3Writer) Abort() error {
+	w.pw.CloseWithError(fmt.Errorf("upload aborted"))
+	<-w.done
+	return nil
+}
@@ -0,0 +1,140 @@
+package compress
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultChunkSize is the uncompressed size of each independent frame in
+// a zstd-chunked object, modeled on the container/storage chunked
+// format.
+const DefaultChunkSize = 4 * 1024 * 1024
+
+// ChunkInfo describes one independently-compressed frame.
+type ChunkInfo struct {
+	UncompressedOffset int64  `json:"uncompressed_offset"`
+	CompressedOffset   int64  `json:"compressed_offset"`
+	Length             int64  `json:"length"`
+	SHA256             string `json:"sha256"`
+}
+
+// tableOfContents is appended after the last frame; its own byte offset
+// is then written as a big-endian uint64 in the final 8 bytes of the
+// object so a later dedup/ranged-read tool can find it without
+// decompressing anything.
+type tableOfContents struct {
+	Chunks []ChunkInfo `json:"chunks"`
+}
+
+// NewChunkedWriter returns a writer that splits its input into
+// chunkSize-byte frames (DefaultChunkSize if chunkSize <= 0), compresses
+// each independently, and appends the TOC footer on Close. Close does
+// not close w, so the caller stays in control of when (or whether) w
+// itself is finalized.
+func NewChunkedWriter(w io.Writer, chunkSize int) io.WriteCloser {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return &chunkedWriter{w: w, chunkSize: chunkSize}
+}
+
+type chunkedWriter struct {
+	w          io.Writer
+	chunkSize  int
+	buf        []byte
+	toc        tableOfContents
+	uncOffset  int64
+	compOffset int64
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := c.chunkSize - len(c.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		c.buf = append(c.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(c.buf) == c.chunkSize {
+			if err := c.flushChunk(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (c *chunkedWriter) flushChunk() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	hasher := sha256.New()
+	hasher.Write(c.buf)
+
+	cw := &countingWriter{w: c.w}
+	enc, err := zstd.NewWriter(cw)
+	if err != nil {
+		return err
+	}
+	if _, err := enc.Write(c.buf); err != nil {
+		enc.Close()
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	c.toc.Chunks = append(c.toc.Chunks, ChunkInfo{
+		UncompressedOffset: c.uncOffset,
+		CompressedOffset:   c.compOffset,
+		Length:             cw.n,
+		SHA256:             hex.EncodeToString(hasher.Sum(nil)),
+	})
+	c.uncOffset += int64(len(c.buf))
+	c.compOffset += cw.n
+	c.buf = c.buf[:0]
+	return nil
+}
+
+func (c *chunkedWriter) Close() error {
+	if err := c.flushChunk(); err != nil {
+		return err
+	}
+
+	tocBytes, err := json.Marshal(c.toc)
+	if err != nil {
+		return err
+	}
+	tocOffset := c.compOffset
+
+	if _, err := c.w.Write(tocBytes); err != nil {
+		return err
+	}
+
+	var footer [8]byte
+	binary.BigEndian.PutUint64(footer[:], uint64(tocOffset))
+	_, err = c.w.Write(footer[:])
+	return err
+}
+
+// countingWriter tracks how many compressed bytes a single chunk's zstd
+// frame takes up, since zstd.Encoder doesn't report this itself.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,35 @@
+// Package compress provides optional zstd compression of LFS objects at
+// rest, transparent to the git-lfs side of the transfer which always
+// deals in plain bytes.
+package compress
+
+import "fmt"
+
+// Mode selects how newly stored objects are compressed.
+type Mode string
+
+const (
+	// None stores objects exactly as received.
+	None Mode = "none"
+	// Zstd compresses the whole object as a single zstd stream.
+	Zstd Mode = "zstd"
+	// ZstdChunked compresses the object as a sequence of independently
+	// decompressible zstd frames, with a chunk table of contents
+	// appended as a footer. This trades a little ratio for the ability
+	// to later fetch/decompress a single chunk (dedup, ranged reads)
+	// without touching the rest of the object.
+	ZstdChunked Mode = "zstd-chunked"
+)
+
+// ParseMode validates a --compress flag or
+// lfs.customtransfer.folderstore.compress git config value.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "":
+		return None, nil
+	case None, Zstd, ZstdChunked:
+		return Mode(s), nil
+	default:
+		return "", fmt.Errorf("unknown compress mode %q, want one of none, zstd, zstd-chunked", s)
+	}
+}
@@ -0,0 +1,68 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var magic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+
+// NewWriter wraps w so every Write is zstd-compressed before reaching
+// it. Close flushes the encoder frame; it does not close w, so the
+// caller stays in control of when (or whether) w itself is finalized.
+func NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+// NewReader wraps r in a streaming zstd decoder. Close releases the
+// decoder and closes r.
+func NewReader(r io.ReadCloser) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{dec: dec, r: r}, nil
+}
+
+type readCloser struct {
+	dec *zstd.Decoder
+	r   io.ReadCloser
+}
+
+func (z *readCloser) Read(p []byte) (int, error) {
+	return z.dec.Read(p)
+}
+
+func (z *readCloser) Close() error {
+	z.dec.Close()
+	return z.r.Close()
+}
+
+// MaybeDecompress peeks at the front of r for the zstd magic number and,
+// if present, wraps r in a decompressing reader so the caller always
+// sees plaintext. Both zstd and zstd-chunked objects start with an
+// ordinary zstd frame, so this single check covers either, and lets
+// retrieve keep serving objects that were written under a different (or
+// no) --compress setting without needing to record which mode was used.
+func MaybeDecompress(r io.ReadCloser) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(len(magic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	combined := combinedReadCloser{Reader: buffered, Closer: r}
+
+	if !bytes.Equal(header, magic) {
+		return combined, nil
+	}
+	return NewReader(combined)
+}
+
+type combinedReadCloser struct {
+	io.Reader
+	io.Closer
+}
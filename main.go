@@ -0,0 +1,41 @@
+// Command lfs-folderstore is a Git LFS custom transfer agent, plus a
+// standalone fsck subcommand for auditing a remote's stored objects.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sinbad/lfs-folderstore/service"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fsck" {
+		runFsck(os.Args[2:])
+		return
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	compress := fs.String("compress", "", "compress newly stored objects: none, zstd or zstd-chunked (default: lfs.customtransfer.folderstore.compress)")
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %v [--compress=none|zstd|zstd-chunked] <remote>\n       %v fsck <remote>\n", os.Args[0], os.Args[0])
+		os.Exit(1)
+	}
+
+	service.Serve(fs.Arg(0), *compress, os.Stdin, os.Stdout, os.Stderr)
+}
+
+func runFsck(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %v fsck <remote>\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	if err := service.Fsck(args[0], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package service
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocate reserves size bytes of disk for f up front, the way
+// object stores such as minio's fsCreateFile do, so multi-GB LFS objects
+// don't fragment across a spinning disk or network-attached share as
+// they grow. It's best-effort: failures are ignored since the copy that
+// follows works fine without it.
+func preallocate(f *os.File, size int64) {
+	if size <= 0 {
+		return
+	}
+	unix.Fallocate(int(f.Fd()), 0, 0, size)
+}
+
+// adviseSequential hints to the kernel that f will be read front-to-back
+// exactly once, so it can read ahead more aggressively.
+func adviseSequential(f *os.File, size int64) {
+	if size <= 0 {
+		return
+	}
+	unix.Fadvise(int(f.Fd()), 0, size, unix.FADV_SEQUENTIAL)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package service
+
+import "os"
+
+// preallocate is a no-op outside Linux, which is where fallocate(2) is
+// available; see the linux build of this file.
+func preallocate(f *os.File, size int64) {}
+
+// adviseSequential is a no-op outside Linux, see preallocate.
+func adviseSequential(f *os.File, size int64) {}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/sinbad/lfs-folderstore/backend"
+	"github.com/sinbad/lfs-folderstore/compress"
+	"github.com/sinbad/lfs-folderstore/util"
+)
+
+// Fsck walks every object on remote and reports any whose content no
+// longer hashes to its own name. Corrupt objects are quarantined rather
+// than deleted outright, so they don't get served to git-lfs again.
+func Fsck(remote string, stdout, stderr io.Writer) error {
+	errWriter := bufio.NewWriter(stderr)
+	outWriter := bufio.NewWriter(stdout)
+	defer errWriter.Flush()
+	defer outWriter.Flush()
+
+	be, err := backend.New(remote)
+	if err != nil {
+		return fmt.Errorf("fsck failed opening %q: %v", remote, err)
+	}
+
+	var checked, quarantined int
+
+	err = be.Walk(func(oid string) error {
+		checked++
+		actual, err := hashObject(be, oid)
+		if err != nil {
+			util.WriteToStderr(fmt.Sprintf("Cannot read %q: %v\n", oid, err), errWriter)
+			return nil
+		}
+
+		if actual != oid {
+			quarantined++
+			util.WriteToStderr(fmt.Sprintf("%v: content hash is %v, quarantining\n", oid, actual), errWriter)
+			if err := be.Quarantine(oid); err != nil {
+				util.WriteToStderr(fmt.Sprintf("Cannot quarantine %q: %v\n", oid, err), errWriter)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("fsck failed walking %q: %v", remote, err)
+	}
+
+	util.WriteToStderr(fmt.Sprintf("Checked %d object(s), quarantined %d\n", checked, quarantined), outWriter)
+
+	return nil
+}
+
+// hashObject hashes oid's decompressed content, matching what retrieve
+// hands to git-lfs, rather than the possibly-compressed bytes on disk -
+// otherwise every object stored with --compress would hash differently
+// from its own name and get quarantined as corrupt.
+func hashObject(be backend.Backend, oid string) (string, error) {
+	rawR, err := be.NewReader(oid)
+	if err != nil {
+		return "", err
+	}
+	defer rawR.Close()
+
+	r, err := compress.MaybeDecompress(rawR)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
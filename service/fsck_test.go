@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/sinbad/lfs-folderstore/backend"
+	"github.com/sinbad/lfs-folderstore/compress"
+)
+
+func TestFsckDoesNotQuarantineCompressedObjects(t *testing.T) {
+	dir := t.TempDir()
+	be, err := backend.New(dir)
+	if err != nil {
+		t.Fatalf("backend.New: %v", err)
+	}
+
+	want := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog"), 1000)
+	sum := sha256.Sum256(want)
+	oid := hex.EncodeToString(sum[:])
+
+	w, err := be.NewWriter(oid, 0)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	zw, err := compress.NewWriter(w)
+	if err != nil {
+		t.Fatalf("compress.NewWriter: %v", err)
+	}
+	if _, err := zw.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := Fsck(dir, &stdout, &stderr); err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+
+	if stderr.Len() != 0 {
+		t.Fatalf("unexpected fsck stderr: %s", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "Checked 1 object(s), quarantined 0") {
+		t.Fatalf("got fsck output %q, want a clean run", stdout.String())
+	}
+	if !be.Exists(oid) {
+		t.Fatalf("object was quarantined and is no longer reachable under its own oid")
+	}
+}
+
+func TestFsckQuarantinesCorruptObjects(t *testing.T) {
+	dir := t.TempDir()
+	be, err := backend.New(dir)
+	if err != nil {
+		t.Fatalf("backend.New: %v", err)
+	}
+
+	const oid = "000000000000000000000000000000000000000000000000000000000000000f"
+	w, err := be.NewWriter(oid, 0)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if _, err := w.Write([]byte("this does not hash to the oid above")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := Fsck(dir, &stdout, &stderr); err != nil {
+		t.Fatalf("Fsck: %v", err)
+	}
+
+	if !strings.Contains(stdout.String(), "Checked 1 object(s), quarantined 1") {
+		t.Fatalf("got fsck output %q, want one quarantined object", stdout.String())
+	}
+	if be.Exists(oid) {
+		t.Fatalf("corrupt object is still reachable under its own oid")
+	}
+}
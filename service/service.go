@@ -2,68 +2,171 @@ package service
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sinbad/lfs-folderstore/api"
+	"github.com/sinbad/lfs-folderstore/backend"
+	"github.com/sinbad/lfs-folderstore/compress"
 	"github.com/sinbad/lfs-folderstore/util"
 )
 
-// Serve starts the protocol server
-func Serve(baseDir string, stdin io.Reader, stdout, stderr io.Writer) {
+// ioState bundles the stdout/stderr writers the protocol uses together
+// with the mutex that serializes the JSON writes to stdout once multiple
+// workers are sending responses/progress concurrently.
+type ioState struct {
+	writer, errWriter *bufio.Writer
+	mu                sync.Mutex
+}
+
+func (s *ioState) sendResponse(resp interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return api.SendResponse(resp, s.writer, s.errWriter)
+}
+
+func (s *ioState) sendError(oid string, code int, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	api.SendTransferError(oid, code, msg, s.writer, s.errWriter)
+}
+
+func (s *ioState) sendProgress(oid string, readSoFar int64, readSinceLast int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	api.SendProgress(oid, readSoFar, readSinceLast, s.writer, s.errWriter)
+}
+
+func (s *ioState) logf(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	util.WriteToStderr(fmt.Sprintf(format, args...), s.errWriter)
+}
+
+// Serve starts the protocol server. remote is resolved to a storage
+// backend via backend.New once the init event arrives; it may be a bare
+// local path (the historical baseDir) or a scheme-qualified URL such as
+// s3://bucket/prefix.
+//
+// download/upload events are handed to a bounded pool of worker
+// goroutines sized from the "concurrenttransfers" value git-lfs sends
+// with init, matching what native LFS HTTP transfers already do for
+// concurrent checkouts/pushes.
+//
+// compressFlag selects how newly stored objects are compressed
+// ("zstd", "zstd-chunked" or "" / "none"); an empty value falls back to
+// git config lfs.customtransfer.folderstore.compress. Retrieval always
+// transparently decompresses whatever it finds, regardless of this
+// setting, so it's safe to change between uploads.
+func Serve(remote string, compressFlag string, stdin io.Reader, stdout, stderr io.Writer) {
 
 	scanner := bufio.NewScanner(stdin)
-	writer := bufio.NewWriter(stdout)
-	errWriter := bufio.NewWriter(stderr)
+	ioSt := &ioState{
+		writer:    bufio.NewWriter(stdout),
+		errWriter: bufio.NewWriter(stderr),
+	}
 
 	gitDir, err := gitDir()
 	if err != nil {
-		util.WriteToStderr(fmt.Sprintf("Unable to retrieve git dir: %v\n", err), errWriter)
+		ioSt.logf("Unable to retrieve git dir: %v\n", err)
+		return
+	}
+
+	compressMode, err := resolveCompressMode(compressFlag)
+	if err != nil {
+		ioSt.logf("Invalid compress mode: %v\n", err)
 		return
 	}
 
+	var be backend.Backend
+	var jobs chan func()
+	var workers sync.WaitGroup
+	poolStarted := false
+
+	startPool := func(n int) {
+		if n < 1 {
+			n = 1
+		}
+		jobs = make(chan func(), n)
+		for i := 0; i < n; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for job := range jobs {
+					job()
+				}
+			}()
+		}
+		poolStarted = true
+	}
+
 	for scanner.Scan() {
 		line := scanner.Text()
 		var req api.Request
 
 		if err := json.Unmarshal([]byte(line), &req); err != nil {
-			util.WriteToStderr(fmt.Sprintf("Unable to parse request: %v\n", line), errWriter)
+			ioSt.logf("Unable to parse request: %v\n", line)
 			continue
 		}
 
 		switch req.Event {
 		case "init":
 			resp := &api.InitResponse{}
-			if len(baseDir) == 0 {
-				resp.Error = &api.TransferError{Code: 9, Message: "Base directory not specified, check config"}
+			if len(remote) == 0 {
+				resp.Error = &api.TransferError{Code: 9, Message: "Base directory/remote not specified, check config"}
+			} else if be, err = backend.New(remote); err != nil {
+				resp.Error = &api.TransferError{Code: 10, Message: fmt.Sprintf("Cannot initialise backend for %q: %v", remote, err)}
 			} else {
-				util.WriteToStderr(fmt.Sprintf("Initialised lfs-folderstore custom adapter for %s\n", req.Operation), errWriter)
+				startPool(req.ConcurrentTransfers)
+				ioSt.logf("Initialised lfs-folderstore custom adapter for %s using %q, concurrency %d\n", req.Operation, remote, req.ConcurrentTransfers)
 			}
-			api.SendResponse(resp, writer, errWriter)
+			ioSt.sendResponse(resp)
 		case "download":
-			util.WriteToStderr(fmt.Sprintf("Received download request for %s\n", req.Oid), errWriter)
-			retrieve(baseDir, gitDir, req.Oid, req.Size, req.Action, writer, errWriter)
+			req := req
+			ioSt.logf("Received download request for %s\n", req.Oid)
+			if be == nil {
+				ioSt.sendError(req.Oid, 11, "Backend not initialised, check init response for errors")
+				continue
+			}
+			if !poolStarted {
+				startPool(1)
+			}
+			jobs <- func() {
+				retrieve(be, gitDir, req.Oid, req.Size, req.Action, ioSt)
+			}
 		case "upload":
-			util.WriteToStderr(fmt.Sprintf("Received upload request for %s\n", req.Oid), errWriter)
-			store(baseDir, req.Oid, req.Size, req.Action, req.Path, writer, errWriter)
+			req := req
+			ioSt.logf("Received upload request for %s\n", req.Oid)
+			if be == nil {
+				ioSt.sendError(req.Oid, 11, "Backend not initialised, check init response for errors")
+				continue
+			}
+			if !poolStarted {
+				startPool(1)
+			}
+			jobs <- func() {
+				store(be, req.Oid, req.Size, req.Action, req.Path, compressMode, ioSt)
+			}
 		case "terminate":
-			util.WriteToStderr("Terminating test custom adapter gracefully.\n", errWriter)
-			break
+			ioSt.logf("Terminating test custom adapter gracefully.\n")
+			if poolStarted {
+				close(jobs)
+				workers.Wait()
+			}
+			return
 		}
 	}
 
 }
 
-func storagePath(baseDir string, oid string) string {
-	// Use same folder split as lfs itself
-	fld := filepath.Join(baseDir, oid[0:2], oid[2:4])
-	return filepath.Join(fld, oid)
-}
-
 func downloadTempPath(gitDir string, oid string) string {
 	// Download to a subfolder of repo so that git-lfs's final rename can work
 	// It won't work if TEMP is on another drive otherwise
@@ -73,19 +176,13 @@ func downloadTempPath(gitDir string, oid string) string {
 	return filepath.Join(tmpfld, fmt.Sprintf("%v.tmp", oid))
 }
 
-func retrieve(baseDir, gitDir, oid string, size int64, a *api.Action, writer, errWriter *bufio.Writer) {
+func retrieve(be backend.Backend, gitDir, oid string, size int64, a *api.Action, ioSt *ioState) {
 
 	// We just use a shared DB of objects stored by OID across all repos
-	// If user wants to separate, can just use a different folder
-	filePath := storagePath(baseDir, oid)
-	stat, err := os.Stat(filePath)
+	// If user wants to separate, can just use a different remote
+	statSize, err := be.Stat(oid)
 	if err != nil {
-		api.SendTransferError(oid, 3, fmt.Sprintf("Cannot stat %q: %v", filePath, err), writer, errWriter)
-		return
-	}
-
-	if !stat.Mode().IsRegular() {
-		api.SendTransferError(oid, 4, fmt.Sprintf("Store corruption, %q is not a regular file", filePath), writer, errWriter)
+		ioSt.sendError(oid, 3, fmt.Sprintf("Cannot stat %q: %v", oid, err))
 		return
 	}
 
@@ -94,161 +191,272 @@ func retrieve(baseDir, gitDir, oid string, size int64, a *api.Action, writer, er
 	dlfilename := downloadTempPath(gitDir, oid)
 	dlFile, err := os.OpenFile(dlfilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
-		api.SendTransferError(oid, 5, fmt.Sprintf("Error creating temp file for %q: %v", filePath, err), writer, errWriter)
+		ioSt.sendError(oid, 5, fmt.Sprintf("Error creating temp file for %q: %v", oid, err))
 		return
 	}
 	defer dlFile.Close()
 
-	f, err := os.OpenFile(filePath, os.O_RDONLY, 0)
+	rawSrc, err := be.NewReader(oid)
 	if err != nil {
-		api.SendTransferError(oid, 6, fmt.Sprintf("Cannot read data from %q: %v", filePath, err), writer, errWriter)
+		ioSt.sendError(oid, 6, fmt.Sprintf("Cannot read data for %q: %v", oid, err))
 		os.Remove(dlfilename)
 		return
 	}
-	defer f.Close()
+	if f, ok := rawSrc.(*os.File); ok {
+		adviseSequential(f, statSize)
+	}
+
+	src, err := compress.MaybeDecompress(rawSrc)
+	if err != nil {
+		ioSt.sendError(oid, 6, fmt.Sprintf("Cannot decompress %q: %v", oid, err))
+		rawSrc.Close()
+		os.Remove(dlfilename)
+		return
+	}
+	defer src.Close()
 
 	cb := func(totalSize, readSoFar int64, readSinceLast int) error {
-		api.SendProgress(oid, readSoFar, readSinceLast, writer, errWriter)
+		ioSt.sendProgress(oid, readSoFar, readSinceLast)
 		return nil
 	}
 
-	err = copyFileContents(stat.Size(), f, dlFile, cb)
+	// Objects may be stored compressed, so the stored size from be.Stat
+	// isn't necessarily the plaintext size git-lfs asked for; prefer the
+	// size it told us to expect and let the hash check below be the
+	// final word on whether the bytes actually match.
+	copySize := statSize
+	if size > 0 {
+		copySize = size
+	}
+	preallocate(dlFile, copySize)
+
+	hasher := sha256.New()
+	err = copyFileContents(copySize, src, io.MultiWriter(dlFile, hasher), cb)
 	if err != nil {
-		api.SendTransferError(oid, 7, fmt.Sprintf("Error copy file from %q: %v", filePath, err), writer, errWriter)
+		ioSt.sendError(oid, 7, fmt.Sprintf("Error copying %v: %v", oid, err))
 		dlFile.Close()
 		os.Remove(dlfilename)
 		return
 	}
 
 	if err := dlFile.Close(); err != nil {
-		api.SendTransferError(oid, 5, fmt.Sprintf("can't close tempfile %q: %v", dlfilename, err), writer, errWriter)
+		ioSt.sendError(oid, 5, fmt.Sprintf("can't close tempfile %q: %v", dlfilename, err))
+		os.Remove(dlfilename)
+		return
+	}
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != oid {
+		ioSt.sendError(oid, 9, fmt.Sprintf("content hash does not match OID %v: got %v", oid, actual))
 		os.Remove(dlfilename)
 		return
 	}
 
 	// completed
 	complete := &api.TransferResponse{Event: "complete", Oid: oid, Path: dlfilename, Error: nil}
-	err = api.SendResponse(complete, writer, errWriter)
-	if err != nil {
-		util.WriteToStderr(fmt.Sprintf("Unable to send completion message: %v\n", err), errWriter)
+	if err := ioSt.sendResponse(complete); err != nil {
+		ioSt.logf("Unable to send completion message: %v\n", err)
 	}
 }
 
 type copyCallback func(totalSize int64, readSoFar int64, readSinceLast int) error
 
-func copyFileContents(size int64, src, dst *os.File, cb copyCallback) error {
-	// copy file in chunks (4K is usual block size of disks)
-	const blockSize int64 = 4 * 1024 * 16
+// copyBufferPool hands out reusable 1 MiB buffers for copyFileContents,
+// so repeated transfers of large LFS objects don't keep allocating and
+// GC-ing big blocks.
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 1024*1024)
+		return &buf
+	},
+}
+
+// progressInterval/progressTimeout bound how often copyFileContents
+// reports progress: at most once per progressInterval bytes, or once
+// per progressTimeout, whichever comes first. Reporting every 1 MiB
+// buffer instead would dominate CPU with JSON chatter to git-lfs on
+// stdout for very large files.
+const (
+	progressInterval = 16 * 1024 * 1024
+	progressTimeout  = 200 * time.Millisecond
+)
 
-	// Read precisely the correct number of bytes
-	bytesLeft := size
-	for bytesLeft > 0 {
-		nextBlock := blockSize
-		if nextBlock < bytesLeft {
-			nextBlock = bytesLeft
-		}
-		n, err := io.CopyN(dst, src, nextBlock)
-		bytesLeft -= n
-		if err != nil && err != io.EOF {
-			return err
-		}
-		readSoFar := size - bytesLeft
-		if cb != nil {
-			cb(size, readSoFar, int(n))
-		}
-	}
-	return nil
+// progressWriter wraps dst and throttles calls to cb to progressInterval
+// bytes or progressTimeout, whichever comes first, always reporting the
+// final write so the caller sees 100% exactly once.
+type progressWriter struct {
+	dst         io.Writer
+	size        int64
+	cb          copyCallback
+	readSoFar   int64
+	sinceReport int64
+	lastReport  time.Time
 }
 
-func store(baseDir string, oid string, size int64, a *api.Action, fromPath string, writer, errWriter *bufio.Writer) {
-	statFrom, err := os.Stat(fromPath)
-	if err != nil {
-		api.SendTransferError(oid, 13, fmt.Sprintf("Cannot stat %q: %v", fromPath, err), writer, errWriter)
-		return
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.dst.Write(b)
+	p.readSoFar += int64(n)
+	p.sinceReport += int64(n)
+
+	if p.cb != nil && (p.readSoFar == p.size || p.sinceReport >= progressInterval || time.Since(p.lastReport) >= progressTimeout) {
+		p.cb(p.size, p.readSoFar, int(p.sinceReport))
+		p.sinceReport = 0
+		p.lastReport = time.Now()
 	}
 
-	destPath := storagePath(baseDir, oid)
+	return n, err
+}
 
-	statDest, err := os.Stat(destPath)
-	if err == nil {
-		// if file exists, skip if already the same size
-		if statFrom.Size() == statDest.Size() {
-			util.WriteToStderr(fmt.Sprintf("Skipping %v, already stored", oid), errWriter)
+func copyFileContents(size int64, src io.Reader, dst io.Writer, cb copyCallback) error {
+	bufp := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(bufp)
 
-			// send full progress
-			api.SendProgress(oid, statFrom.Size(), int(statFrom.Size()), writer, errWriter)
-			// send completion
-			complete := &api.TransferResponse{Event: "complete", Oid: oid, Error: nil}
-			err = api.SendResponse(complete, writer, errWriter)
-			if err != nil {
-				util.WriteToStderr(fmt.Sprintf("Unable to send completion message: %v\n", err), errWriter)
-			}
-			return
-		}
-	}
+	pw := &progressWriter{dst: dst, size: size, cb: cb, lastReport: time.Now()}
+	_, err := io.CopyBuffer(pw, io.LimitReader(src, size), *bufp)
+	return err
+}
 
-	err = os.MkdirAll(filepath.Dir(destPath), 0777)
+func store(be backend.Backend, oid string, size int64, a *api.Action, fromPath string, compressMode compress.Mode, ioSt *ioState) {
+	statFrom, err := os.Stat(fromPath)
 	if err != nil {
-		api.SendTransferError(oid, 14, fmt.Sprintf("Cannot create dir %q: %v", filepath.Dir(destPath), err), writer, errWriter)
+		ioSt.sendError(oid, 13, fmt.Sprintf("Cannot stat %q: %v", fromPath, err))
 		return
 	}
 
-	// write a temp file in same folder, then rename
-	tempPath := fmt.Sprintf("%v.tmp", destPath)
-	if _, err := os.Stat(tempPath); err == nil {
-		// delete temp file
-		err := os.Remove(tempPath)
-		if err != nil && !os.IsNotExist(err) {
-			api.SendTransferError(oid, 14, fmt.Sprintf("Cannot remove existing temp file %q: %v", tempPath, err), writer, errWriter)
-			return
+	// if already stored with the same size, skip; stored size is only
+	// comparable to the local file when it isn't compressed
+	if destSize, err := be.Stat(oid); compressMode == compress.None && err == nil && destSize == statFrom.Size() {
+		ioSt.logf("Skipping %v, already stored", oid)
+
+		// send full progress
+		ioSt.sendProgress(oid, statFrom.Size(), int(statFrom.Size()))
+		// send completion
+		complete := &api.TransferResponse{Event: "complete", Oid: oid, Error: nil}
+		if err := ioSt.sendResponse(complete); err != nil {
+			ioSt.logf("Unable to send completion message: %v\n", err)
 		}
+		return
 	}
 
 	srcf, err := os.OpenFile(fromPath, os.O_RDONLY, 0)
 	if err != nil {
-		api.SendTransferError(oid, 15, fmt.Sprintf("Cannot read data from %q: %v", fromPath, err), writer, errWriter)
+		ioSt.sendError(oid, 15, fmt.Sprintf("Cannot read data from %q: %v", fromPath, err))
 		return
 	}
 	defer srcf.Close()
+	adviseSequential(srcf, statFrom.Size())
+
+	// Only hint the final size when the stored bytes will exactly match
+	// statFrom.Size(); compression changes the final size, and a backend
+	// that preallocates based on a wrong hint could pad the object with
+	// zeros.
+	sizeHint := int64(0)
+	if compressMode == compress.None {
+		sizeHint = statFrom.Size()
+	}
+	rawDst, err := be.NewWriter(oid, sizeHint)
+	if err != nil {
+		ioSt.sendError(oid, 16, fmt.Sprintf("Cannot open destination for %v: %v", oid, err))
+		return
+	}
 
-	dstf, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	dst, err := compressingWriter(rawDst, compressMode)
 	if err != nil {
-		api.SendTransferError(oid, 16, fmt.Sprintf("Cannot open temp file for writing %q: %v", tempPath, err), writer, errWriter)
+		ioSt.sendError(oid, 16, fmt.Sprintf("Cannot set up %v compression for %v: %v", compressMode, oid, err))
+		rawDst.Abort()
 		return
 	}
-	defer dstf.Close()
 
 	cb := func(totalSize, readSoFar int64, readSinceLast int) error {
-		api.SendProgress(oid, readSoFar, readSinceLast, writer, errWriter)
+		ioSt.sendProgress(oid, readSoFar, readSinceLast)
 		return nil
 	}
 
-	err = copyFileContents(statFrom.Size(), srcf, dstf, cb)
+	// hasher taps the plaintext bytes as they're read from fromPath,
+	// before dst (if compressing) compresses them, so integrity
+	// verification always runs against the decompressed content. rawDst
+	// only stages bytes at this point: nothing below is visible to a
+	// concurrent reader until rawDst.Commit succeeds, so a corrupt or
+	// mismatched upload never lands in the shared store.
+	hasher := sha256.New()
+	err = copyFileContents(statFrom.Size(), srcf, io.MultiWriter(dst, hasher), cb)
 	if err != nil {
-		api.SendTransferError(oid, 17, fmt.Sprintf("Error writing temp file %q: %v", tempPath, err), writer, errWriter)
-		dstf.Close()
-		os.Remove(tempPath)
+		ioSt.sendError(oid, 17, fmt.Sprintf("Error writing %v: %v", oid, err))
+		dst.Close()
+		rawDst.Abort()
 		return
 	}
 
-	// now rename
-	dstf.Close()
-	err = os.Rename(tempPath, destPath)
-	if err != nil {
-		api.SendTransferError(oid, 18, fmt.Sprintf("Error moving temp file to final location: %v", err), writer, errWriter)
-		os.Remove(tempPath)
+	// Flushes the compression framing only; rawDst is still unpublished.
+	if err := dst.Close(); err != nil {
+		ioSt.sendError(oid, 18, fmt.Sprintf("Error finalising upload for %v: %v", oid, err))
+		rawDst.Abort()
+		return
+	}
+
+	if size > 0 && statFrom.Size() != size {
+		ioSt.sendError(oid, 19, fmt.Sprintf("content size does not match OID %v: expected %d, got %d", oid, size, statFrom.Size()))
+		rawDst.Abort()
+		return
+	}
+
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != oid {
+		ioSt.sendError(oid, 20, fmt.Sprintf("content hash does not match OID %v: got %v", oid, actual))
+		rawDst.Abort()
+		return
+	}
+
+	if err := rawDst.Commit(); err != nil {
+		ioSt.sendError(oid, 21, fmt.Sprintf("Error publishing %v: %v", oid, err))
 		return
 	}
 
 	// completed
 	complete := &api.TransferResponse{Event: "complete", Oid: oid, Error: nil}
-	err = api.SendResponse(complete, writer, errWriter)
-	if err != nil {
-		util.WriteToStderr(fmt.Sprintf("Unable to send completion message: %v\n", err), errWriter)
+	if err := ioSt.sendResponse(complete); err != nil {
+		ioSt.logf("Unable to send completion message: %v\n", err)
 	}
 
 }
 
+// compressingWriter wraps w so data written to it is stored under mode.
+// Its Close only flushes the compression framing (if any); w itself is
+// published separately, once the caller has verified the data.
+func compressingWriter(w io.Writer, mode compress.Mode) (io.WriteCloser, error) {
+	switch mode {
+	case compress.None, "":
+		return nopWriteCloser{w}, nil
+	case compress.Zstd:
+		return compress.NewWriter(w)
+	case compress.ZstdChunked:
+		return compress.NewChunkedWriter(w, compress.DefaultChunkSize), nil
+	default:
+		return nil, fmt.Errorf("unknown compress mode %q", mode)
+	}
+}
+
+// nopWriteCloser adapts an io.Writer to io.WriteCloser whose Close is a
+// no-op, for the uncompressed case where there's no framing to flush.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// resolveCompressMode honors an explicit --compress flag value, falling
+// back to lfs.customtransfer.folderstore.compress in git config.
+func resolveCompressMode(flag string) (compress.Mode, error) {
+	if flag != "" {
+		return compress.ParseMode(flag)
+	}
+
+	cmd := util.NewCmd("git", "config", "--get", "lfs.customtransfer.folderstore.compress")
+	out, err := cmd.Output()
+	if err != nil {
+		return compress.None, nil
+	}
+	return compress.ParseMode(strings.TrimSpace(string(out)))
+}
+
 func gitDir() (string, error) {
 	cmd := util.NewCmd("git", "rev-parse", "--git-dir")
 	out, err := cmd.Output()
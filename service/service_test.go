@@ -0,0 +1,96 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestServeTerminateStopsReadingStdin guards against terminate only
+// breaking out of the event switch instead of ending Serve: a queued
+// line behind terminate must never be processed.
+func TestServeTerminateStopsReadingStdin(t *testing.T) {
+	stdin := strings.NewReader(`{"event":"terminate"}` + "\n" + `{"event":"upload","oid":"boom"}` + "\n")
+	var stdout, stderr bytes.Buffer
+
+	done := make(chan struct{})
+	go func() {
+		Serve("", "", stdin, &stdout, &stderr)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after terminate")
+	}
+
+	if strings.Contains(stdout.String(), "boom") {
+		t.Fatalf("Serve kept processing events queued behind terminate: %s", stdout.String())
+	}
+}
+
+func TestCopyFileContentsRoundTrip(t *testing.T) {
+	want := bytes.Repeat([]byte("0123456789"), 1000)
+	src := bytes.NewReader(want)
+	var dst bytes.Buffer
+
+	if err := copyFileContents(int64(len(want)), src, &dst, nil); err != nil {
+		t.Fatalf("copyFileContents: %v", err)
+	}
+	if !bytes.Equal(dst.Bytes(), want) {
+		t.Fatalf("got %d bytes, want %d", dst.Len(), len(want))
+	}
+}
+
+func TestProgressWriterThrottlesToIntervalAndFinalWrite(t *testing.T) {
+	const size = progressInterval * 2
+	var reports []int64
+	cb := func(totalSize, readSoFar int64, readSinceLast int) error {
+		reports = append(reports, readSoFar)
+		return nil
+	}
+
+	pw := &progressWriter{dst: &bytes.Buffer{}, size: size, cb: cb, lastReport: time.Now()}
+
+	// Writing in small chunks well under progressInterval shouldn't report
+	// on every single write, only once the threshold is crossed.
+	chunk := make([]byte, 1024)
+	var written int64
+	for written < size {
+		n, err := pw.Write(chunk)
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		written += int64(n)
+	}
+
+	if len(reports) == 0 {
+		t.Fatalf("expected at least one progress report")
+	}
+	if got := reports[len(reports)-1]; got != size {
+		t.Fatalf("final report = %d, want %d (every copy must end with a 100%% report)", got, size)
+	}
+	if len(reports) >= int(size/1024) {
+		t.Fatalf("got %d reports for %d writes, progressInterval did not throttle anything", len(reports), size/1024)
+	}
+}
+
+func TestProgressWriterAlwaysReportsFinalWriteEvenBelowInterval(t *testing.T) {
+	const size = 10
+	var reports []int64
+	cb := func(totalSize, readSoFar int64, readSinceLast int) error {
+		reports = append(reports, readSoFar)
+		return nil
+	}
+
+	pw := &progressWriter{dst: &bytes.Buffer{}, size: size, cb: cb, lastReport: time.Now()}
+	if _, err := pw.Write(make([]byte, size)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if len(reports) != 1 || reports[0] != size {
+		t.Fatalf("reports = %v, want exactly one report of %d", reports, size)
+	}
+}